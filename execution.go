@@ -0,0 +1,194 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/encratite/commons"
+	"github.com/encratite/ohlc"
+)
+
+const (
+	positionSizingVolatility = "volatility"
+	positionSizingKelly = "kelly"
+
+	defaultVolatilityWindow = 20
+	defaultTargetRisk = 0.01
+)
+
+// ExecutionConfig turns a raw signal into a realistic, costed fill by
+// configuring position sizing, trading costs, and intrabar exits. A zero
+// value trades full notional at no cost and exits at the holding window's
+// last close, so a long earns the close-to-close rate of change and a short
+// its negation.
+type ExecutionConfig struct {
+	MakerFeeRate float64 `yaml:"makerFeeRate"`
+	TakerFeeRate float64 `yaml:"takerFeeRate"`
+	SlippageBps float64 `yaml:"slippageBps"`
+	// PositionSizing is "fixed" (default), "volatility", or "kelly".
+	PositionSizing string `yaml:"positionSizing"`
+	VolatilityWindow int `yaml:"volatilityWindow"`
+	// TargetRisk is the constant per-trade risk budget that volatility
+	// sizing scales the position up or down to meet.
+	TargetRisk float64 `yaml:"targetRisk"`
+	KellyVarianceWindow int `yaml:"kellyVarianceWindow"`
+	MaxLeverage float64 `yaml:"maxLeverage"`
+	RoiStopLossPercentage float64 `yaml:"roiStopLossPercentage"`
+	RoiTakeProfitPercentage float64 `yaml:"roiTakeProfitPercentage"`
+	TrailingStopCallbackPercentage float64 `yaml:"trailingStopCallbackPercentage"`
+}
+
+// getSampleDates extracts the dates of a slice of samples, in order.
+func getSampleDates(samples []sample) []time.Time {
+	dates := make([]time.Time, len(samples))
+	for i, s := range samples {
+		dates[i] = s.date
+	}
+	return dates
+}
+
+// runBacktestFromSignals turns each day's signal into a long trade when it
+// is above longThreshold and a short trade when it is below shortThreshold
+// (a zero return otherwise), each held for holdingTime days and
+// costed/sized/exited according to configuration.Execution, using the
+// asset's intraday bars rather than only the close-to-close label.
+func runBacktestFromSignals(ctx *FeatureContext, dates []time.Time, signals []float64, holdingTime int, longThreshold float64, shortThreshold float64) ([]float64, []float64) {
+	config := configuration.Execution
+	longReturns := make([]float64, len(signals))
+	shortReturns := make([]float64, len(signals))
+	for i, signal := range signals {
+		date := dates[i]
+		entryTime := date.Add(sessionEnd)
+		exitTime := date.AddDate(0, 0, holdingTime).Add(sessionEnd)
+		bars := getBarsForHoldingWindow(ctx.HourlyRecords, entryTime, exitTime)
+		if signal > longThreshold {
+			positionSize := getPositionSize(config, ctx, date, 1.0, signal)
+			longReturns[i] = simulateExecutionReturn(bars, 1.0, positionSize, config)
+		}
+		if signal < shortThreshold {
+			positionSize := getPositionSize(config, ctx, date, -1.0, signal)
+			shortReturns[i] = simulateExecutionReturn(bars, -1.0, positionSize, config)
+		}
+	}
+	return longReturns, shortReturns
+}
+
+// getBarsForHoldingWindow returns the bars in [startDate, endDate], in
+// order. The first bar's close is the entry fill price and the last bar is
+// the time-based exit if no stop/take-profit triggers first.
+func getBarsForHoldingWindow(records []ohlc.Record, startDate time.Time, endDate time.Time) []ohlc.Record {
+	startIndex := sort.Search(len(records), func (i int) bool {
+		return !records[i].Timestamp.Before(startDate)
+	})
+	endIndex := sort.Search(len(records), func (i int) bool {
+		return records[i].Timestamp.After(endDate)
+	})
+	if startIndex >= endIndex {
+		return nil
+	}
+	return records[startIndex:endIndex]
+}
+
+// simulateExecutionReturn walks bars bar by bar from the entry fill,
+// checking the stop-loss, trailing stop, and take-profit thresholds in that
+// order (the conservative assumption when several could trigger within the
+// same bar), and falls back to a time-based exit at the last bar's close.
+// The entry and any stop-driven exit are assumed to cross the book and pay
+// the taker fee; a take-profit exit is assumed to rest as a limit order and
+// pays the maker fee instead.
+func simulateExecutionReturn(bars []ohlc.Record, direction float64, positionSize float64, config ExecutionConfig) float64 {
+	if len(bars) < 2 || positionSize == 0.0 {
+		return 0.0
+	}
+	slippage := config.SlippageBps / 10000.0
+	entryFillPrice := bars[0].Close * (1.0 + direction * slippage)
+	maxFavorableExcursion := 0.0
+	roi := 0.0
+	exitFeeRate := config.TakerFeeRate
+	exited := false
+	for _, bar := range bars[1:] {
+		bestPrice, worstPrice := bar.High, bar.Low
+		if direction < 0.0 {
+			bestPrice, worstPrice = bar.Low, bar.High
+		}
+		bestRoi := direction * (bestPrice - entryFillPrice) / entryFillPrice
+		worstRoi := direction * (worstPrice - entryFillPrice) / entryFillPrice
+		if bestRoi > maxFavorableExcursion {
+			maxFavorableExcursion = bestRoi
+		}
+		if config.RoiStopLossPercentage > 0.0 && worstRoi <= - config.RoiStopLossPercentage {
+			roi = - config.RoiStopLossPercentage
+			exited = true
+		} else if config.TrailingStopCallbackPercentage > 0.0 && maxFavorableExcursion > 0.0 &&
+			worstRoi <= maxFavorableExcursion - config.TrailingStopCallbackPercentage {
+			roi = maxFavorableExcursion - config.TrailingStopCallbackPercentage
+			exited = true
+		} else if config.RoiTakeProfitPercentage > 0.0 && bestRoi >= config.RoiTakeProfitPercentage {
+			roi = config.RoiTakeProfitPercentage
+			exitFeeRate = config.MakerFeeRate
+			exited = true
+		}
+		if exited {
+			break
+		}
+	}
+	if !exited {
+		exitPrice := bars[len(bars) - 1].Close
+		roi = direction * (exitPrice - entryFillPrice) / entryFillPrice
+	}
+	netRoi := roi - config.TakerFeeRate - exitFeeRate
+	return netRoi * positionSize
+}
+
+// getPositionSize scales a full-notional trade by config.PositionSizing.
+// "volatility" targets a constant risk budget against the asset's recent
+// realized volatility; "kelly" sizes proportionally to the model's signal
+// (a proxy for predicted mean return) over its recent return variance.
+// Anything else, including the empty default, trades full notional.
+func getPositionSize(config ExecutionConfig, ctx *FeatureContext, date time.Time, direction float64, signal float64) float64 {
+	switch config.PositionSizing {
+	case positionSizingVolatility:
+		window := config.VolatilityWindow
+		if window <= 0 {
+			window = defaultVolatilityWindow
+		}
+		returns, ok := getReturnsSeries(date, ctx.AssetMap, window)
+		if !ok {
+			return 0.0
+		}
+		stdDev := commons.StdDev(returns)
+		if stdDev == 0.0 {
+			return 0.0
+		}
+		targetRisk := config.TargetRisk
+		if targetRisk == 0.0 {
+			targetRisk = defaultTargetRisk
+		}
+		return capLeverage(targetRisk / stdDev, config.MaxLeverage)
+	case positionSizingKelly:
+		window := config.KellyVarianceWindow
+		if window <= 0 {
+			window = defaultVolatilityWindow
+		}
+		returns, ok := getReturnsSeries(date, ctx.AssetMap, window)
+		if !ok {
+			return 0.0
+		}
+		stdDev := commons.StdDev(returns)
+		variance := stdDev * stdDev
+		if variance == 0.0 {
+			return 0.0
+		}
+		return capLeverage(direction * signal / variance, config.MaxLeverage)
+	default:
+		return 1.0
+	}
+}
+
+func capLeverage(size float64, maxLeverage float64) float64 {
+	if maxLeverage <= 0.0 {
+		return size
+	}
+	return math.Max(- maxLeverage, math.Min(maxLeverage, size))
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/encratite/commons"
+	"github.com/encratite/ohlc"
+)
+
+// FeatureConfig names a Feature implementation and its parameters, e.g.
+// "{kind: momentum, params: {horizon: 5}}".
+type FeatureConfig struct {
+	Kind string `yaml:"kind"`
+	Params map[string]float64 `yaml:"params"`
+}
+
+// FeatureContext carries the price/volume history a Feature needs to
+// compute its value for a given date, for one asset at a time.
+type FeatureContext struct {
+	Symbol string
+	AssetMap timePriceMap
+	VolumeMap timeVolumeMap
+	ReferenceMap timePriceMap
+	IndexMap timePriceMap
+	// AssetMaps holds every configured asset's price history, keyed by
+	// symbol, for cross-sectional features such as momentum rank.
+	AssetMaps map[string]timePriceMap
+	// HourlyRecords is the asset's raw Binance H1 history, sorted by
+	// timestamp, used by the backtest to check stop/take-profit/trailing
+	// exits bar by bar instead of only at the close-to-close label.
+	HourlyRecords []ohlc.Record
+}
+
+// Feature computes a single named regression input from a FeatureContext.
+// Compute returns ok=false when it cannot be computed for the given date,
+// e.g. due to missing history, in which case the whole sample is dropped.
+type Feature interface {
+	Name() string
+	Compute(date time.Time, ctx *FeatureContext) (float64, bool)
+}
+
+func buildFeatures(configs []FeatureConfig) ([]Feature, error) {
+	features := make([]Feature, len(configs))
+	for i, config := range configs {
+		feature, err := newFeature(config)
+		if err != nil {
+			return nil, err
+		}
+		features[i] = feature
+	}
+	return features, nil
+}
+
+func newFeature(config FeatureConfig) (Feature, error) {
+	switch config.Kind {
+	case "momentum":
+		return &momentumFeature{horizon: getParamInt(config.Params, "horizon", 1)}, nil
+	case "reference":
+		return &referenceFeature{horizon: getParamInt(config.Params, "horizon", 1)}, nil
+	case "index":
+		return &indexFeature{horizon: getParamInt(config.Params, "horizon", 1)}, nil
+	case "volatility":
+		return &volatilityFeature{window: getParamInt(config.Params, "window", 20)}, nil
+	case "zscore":
+		return &zScoreFeature{window: getParamInt(config.Params, "window", 20)}, nil
+	case "rsi":
+		return &rsiFeature{window: getParamInt(config.Params, "window", 14)}, nil
+	case "macd":
+		return &macdFeature{
+			fastWindow: getParamInt(config.Params, "fastWindow", 12),
+			slowWindow: getParamInt(config.Params, "slowWindow", 26),
+		}, nil
+	case "obv":
+		return &obvFeature{window: getParamInt(config.Params, "window", 20)}, nil
+	case "correlation":
+		return &correlationFeature{window: getParamInt(config.Params, "window", 20)}, nil
+	case "weekday":
+		return &weekdayFeature{day: time.Weekday(getParamInt(config.Params, "day", 0))}, nil
+	case "month":
+		return &monthFeature{month: time.Month(getParamInt(config.Params, "month", 1))}, nil
+	case "momentumRank":
+		return &momentumRankFeature{horizon: getParamInt(config.Params, "horizon", 1)}, nil
+	default:
+		return nil, fmt.Errorf("unknown feature kind \"%s\"", config.Kind)
+	}
+}
+
+func getParamInt(params map[string]float64, key string, fallback int) int {
+	if value, ok := params[key]; ok {
+		return int(value)
+	}
+	return fallback
+}
+
+// momentumFeature is the asset's own rate of change over horizon days.
+type momentumFeature struct {
+	horizon int
+}
+
+func (f *momentumFeature) Name() string {
+	return fmt.Sprintf("Momentum (%dd)", f.horizon)
+}
+
+func (f *momentumFeature) Compute(date time.Time, ctx *FeatureContext) (float64, bool) {
+	currentClose, exists := ctx.AssetMap[date]
+	if !exists {
+		return 0.0, false
+	}
+	previousClose, exists := ctx.AssetMap[date.AddDate(0, 0, - f.horizon)]
+	if !exists {
+		return 0.0, false
+	}
+	return getRateOfChange(currentClose, previousClose), true
+}
+
+// referenceFeature is the BTC momentum over horizon days, used as a market
+// beta proxy. It is always zero for BTC itself.
+type referenceFeature struct {
+	horizon int
+}
+
+func (f *referenceFeature) Name() string {
+	return "BTC"
+}
+
+func (f *referenceFeature) Compute(date time.Time, ctx *FeatureContext) (float64, bool) {
+	if ctx.Symbol == bitcoinSymbol {
+		return 0.0, true
+	}
+	currentClose, exists := ctx.ReferenceMap[date]
+	if !exists {
+		return 0.0, false
+	}
+	previousClose, exists := ctx.ReferenceMap[date.AddDate(0, 0, - f.horizon)]
+	if !exists {
+		return 0.0, false
+	}
+	return getRateOfChange(currentClose, previousClose), true
+}
+
+// indexFeature is the configured index's momentum over horizon days. The
+// index is daily Barchart data, so the closest prior record is used instead
+// of requiring an exact date match.
+type indexFeature struct {
+	horizon int
+}
+
+func (f *indexFeature) Name() string {
+	return configuration.IndexSymbol
+}
+
+func (f *indexFeature) Compute(date time.Time, ctx *FeatureContext) (float64, bool) {
+	currentDate, currentClose, exists := getClosestRecord(date, ctx.IndexMap)
+	if !exists {
+		return 0.0, false
+	}
+	_, previousClose, exists := getClosestRecord(currentDate.AddDate(0, 0, - f.horizon), ctx.IndexMap)
+	if !exists {
+		return 0.0, false
+	}
+	return getRateOfChange(currentClose, previousClose), true
+}
+
+// volatilityFeature is the rolling standard deviation of daily returns.
+type volatilityFeature struct {
+	window int
+}
+
+func (f *volatilityFeature) Name() string {
+	return fmt.Sprintf("Vol (%dd)", f.window)
+}
+
+func (f *volatilityFeature) Compute(date time.Time, ctx *FeatureContext) (float64, bool) {
+	returns, ok := getReturnsSeries(date, ctx.AssetMap, f.window)
+	if !ok {
+		return 0.0, false
+	}
+	return commons.StdDev(returns), true
+}
+
+// zScoreFeature is the asset's current price expressed as a z-score of its
+// own rolling window, a simple mean-reversion signal.
+type zScoreFeature struct {
+	window int
+}
+
+func (f *zScoreFeature) Name() string {
+	return fmt.Sprintf("Z-Score (%dd)", f.window)
+}
+
+func (f *zScoreFeature) Compute(date time.Time, ctx *FeatureContext) (float64, bool) {
+	prices, ok := getPriceSeries(date, ctx.AssetMap, f.window)
+	if !ok {
+		return 0.0, false
+	}
+	history := prices[:f.window]
+	current := prices[f.window]
+	stdDev := commons.StdDev(history)
+	if stdDev == 0.0 {
+		return 0.0, false
+	}
+	return (current - commons.Mean(history)) / stdDev, true
+}
+
+// rsiFeature is the relative strength index over window days.
+type rsiFeature struct {
+	window int
+}
+
+func (f *rsiFeature) Name() string {
+	return fmt.Sprintf("RSI (%dd)", f.window)
+}
+
+func (f *rsiFeature) Compute(date time.Time, ctx *FeatureContext) (float64, bool) {
+	prices, ok := getPriceSeries(date, ctx.AssetMap, f.window)
+	if !ok {
+		return 0.0, false
+	}
+	gainSum := 0.0
+	lossSum := 0.0
+	for i := 1; i < len(prices); i++ {
+		delta := prices[i] - prices[i - 1]
+		if delta > 0.0 {
+			gainSum += delta
+		} else {
+			lossSum -= delta
+		}
+	}
+	if lossSum == 0.0 {
+		return 100.0, true
+	}
+	relativeStrength := gainSum / lossSum
+	rsi := 100.0 - 100.0 / (1.0 + relativeStrength)
+	return rsi, true
+}
+
+// macdFeature is the difference between a fast and a slow EMA of price,
+// expressed as a fraction of the current price.
+type macdFeature struct {
+	fastWindow int
+	slowWindow int
+}
+
+func (f *macdFeature) Name() string {
+	return fmt.Sprintf("MACD (%d/%d)", f.fastWindow, f.slowWindow)
+}
+
+func (f *macdFeature) Compute(date time.Time, ctx *FeatureContext) (float64, bool) {
+	lookback := f.slowWindow * 3
+	prices, ok := getPriceSeries(date, ctx.AssetMap, lookback)
+	if !ok {
+		return 0.0, false
+	}
+	fastEma := getEma(prices, f.fastWindow)
+	slowEma := getEma(prices, f.slowWindow)
+	currentPrice := prices[len(prices) - 1]
+	return (fastEma - slowEma) / currentPrice, true
+}
+
+// obvFeature is a single-day, on-balance-volume-like signal: the volume of
+// the day's session-end hourly bar, signed by the direction of the daily
+// price move and scaled by that bar's average over the previous window days.
+type obvFeature struct {
+	window int
+}
+
+func (f *obvFeature) Name() string {
+	return fmt.Sprintf("OBV (%dd)", f.window)
+}
+
+func (f *obvFeature) Compute(date time.Time, ctx *FeatureContext) (float64, bool) {
+	currentClose, exists := ctx.AssetMap[date]
+	if !exists {
+		return 0.0, false
+	}
+	previousDate := date.AddDate(0, 0, -1)
+	previousClose, exists := ctx.AssetMap[previousDate]
+	if !exists {
+		return 0.0, false
+	}
+	currentVolume, exists := ctx.VolumeMap[date]
+	if !exists {
+		return 0.0, false
+	}
+	volumes := make([]float64, 0, f.window)
+	for i := 1; i <= f.window; i++ {
+		volume, exists := ctx.VolumeMap[date.AddDate(0, 0, -i)]
+		if !exists {
+			return 0.0, false
+		}
+		volumes = append(volumes, volume)
+	}
+	averageVolume := commons.Mean(volumes)
+	if averageVolume == 0.0 {
+		return 0.0, false
+	}
+	direction := 0.0
+	if currentClose > previousClose {
+		direction = 1.0
+	} else if currentClose < previousClose {
+		direction = -1.0
+	}
+	return direction * currentVolume / averageVolume, true
+}
+
+// correlationFeature is the Pearson correlation between the asset's own
+// returns and BTC's returns over window days.
+type correlationFeature struct {
+	window int
+}
+
+func (f *correlationFeature) Name() string {
+	return fmt.Sprintf("Corr BTC (%dd)", f.window)
+}
+
+func (f *correlationFeature) Compute(date time.Time, ctx *FeatureContext) (float64, bool) {
+	assetReturns, ok := getReturnsSeries(date, ctx.AssetMap, f.window)
+	if !ok {
+		return 0.0, false
+	}
+	referenceReturns, ok := getReturnsSeries(date, ctx.ReferenceMap, f.window)
+	if !ok {
+		return 0.0, false
+	}
+	return getCorrelation(assetReturns, referenceReturns), true
+}
+
+// weekdayFeature is a day-of-week dummy.
+type weekdayFeature struct {
+	day time.Weekday
+}
+
+func (f *weekdayFeature) Name() string {
+	return f.day.String()
+}
+
+func (f *weekdayFeature) Compute(date time.Time, ctx *FeatureContext) (float64, bool) {
+	if date.Weekday() == f.day {
+		return 1.0, true
+	}
+	return 0.0, true
+}
+
+// monthFeature is a month-of-year dummy.
+type monthFeature struct {
+	month time.Month
+}
+
+func (f *monthFeature) Name() string {
+	return f.month.String()
+}
+
+func (f *monthFeature) Compute(date time.Time, ctx *FeatureContext) (float64, bool) {
+	if date.Month() == f.month {
+		return 1.0, true
+	}
+	return 0.0, true
+}
+
+// momentumRankFeature is the asset's cross-sectional rank of momentum among
+// all configured assets, in [0, 1].
+type momentumRankFeature struct {
+	horizon int
+}
+
+func (f *momentumRankFeature) Name() string {
+	return fmt.Sprintf("Mom Rank (%dd)", f.horizon)
+}
+
+func (f *momentumRankFeature) Compute(date time.Time, ctx *FeatureContext) (float64, bool) {
+	momenta := map[string]float64{}
+	for symbol, priceMap := range ctx.AssetMaps {
+		currentClose, exists := priceMap[date]
+		if !exists {
+			continue
+		}
+		previousClose, exists := priceMap[date.AddDate(0, 0, - f.horizon)]
+		if !exists {
+			continue
+		}
+		momenta[symbol] = getRateOfChange(currentClose, previousClose)
+	}
+	ownMomentum, exists := momenta[ctx.Symbol]
+	if !exists || len(momenta) < 2 {
+		return 0.0, false
+	}
+	below := 0
+	for _, momentum := range momenta {
+		if momentum < ownMomentum {
+			below++
+		}
+	}
+	return float64(below) / float64(len(momenta) - 1), true
+}
+
+// getPriceSeries returns the window + 1 asset closes ending at date
+// (inclusive), oldest first.
+func getPriceSeries(date time.Time, priceMap timePriceMap, window int) ([]float64, bool) {
+	prices := make([]float64, window + 1)
+	current := date
+	for i := window; i >= 0; i-- {
+		price, exists := priceMap[current]
+		if !exists {
+			return nil, false
+		}
+		prices[i] = price
+		current = current.AddDate(0, 0, -1)
+	}
+	return prices, true
+}
+
+// getReturnsSeries returns the window daily rates of change ending at date,
+// oldest first.
+func getReturnsSeries(date time.Time, priceMap timePriceMap, window int) ([]float64, bool) {
+	prices, ok := getPriceSeries(date, priceMap, window)
+	if !ok {
+		return nil, false
+	}
+	returns := make([]float64, window)
+	for i := range returns {
+		returns[i] = getRateOfChange(prices[i + 1], prices[i])
+	}
+	return returns, true
+}
+
+// getEma is the exponential moving average of values over period, seeded
+// with the oldest value.
+func getEma(values []float64, period int) float64 {
+	multiplier := 2.0 / (float64(period) + 1.0)
+	result := values[0]
+	for _, value := range values[1:] {
+		result = (value - result) * multiplier + result
+	}
+	return result
+}
+
+func getCorrelation(a []float64, b []float64) float64 {
+	meanA := commons.Mean(a)
+	meanB := commons.Mean(b)
+	covariance := 0.0
+	varianceA := 0.0
+	varianceB := 0.0
+	for i := range a {
+		deltaA := a[i] - meanA
+		deltaB := b[i] - meanB
+		covariance += deltaA * deltaB
+		varianceA += deltaA * deltaA
+		varianceB += deltaB * deltaB
+	}
+	denominator := math.Sqrt(varianceA * varianceB)
+	if denominator == 0.0 {
+		return 0.0
+	}
+	return covariance / denominator
+}
@@ -2,12 +2,10 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"math"
 	"os"
 	"time"
 
-	"github.com/cdipaolo/goml/linear"
 	"github.com/encratite/commons"
 	"github.com/encratite/ohlc"
 	"github.com/olekukonko/tablewriter"
@@ -16,7 +14,6 @@ import (
 
 const (
 	sessionEnd = time.Duration(21) * time.Hour
-	daysPerWeek = 7
 	bitcoinSymbol = "BTCUSDT"
 
 	logisticMethod = "Batch Gradient Ascent"
@@ -38,16 +35,20 @@ type Configuration struct {
 	SplitDate commons.SerializableDate `yaml:"splitDate"`
 	EndDate commons.SerializableDate `yaml:"endDate"`
 	IndexSymbol string `yaml:"indexSymbol"`
-	EnableMomentum bool `yaml:"enableMomentum"`
-	EnableReference bool `yaml:"enableReference"`
-	EnableIndex bool `yaml:"enableIndex"`
-	EnableWeekdays bool `yaml:"enableWeekdays"`
+	Features []FeatureConfig `yaml:"features"`
 	EnableWeekdayFilter bool `yaml:"enableWeekdayFilter"`
 	WeekdayFilter commons.SerializableWeekday `yaml:"weekdayFilter"`
 	HoldingTime int `yaml:"holdingTime"`
+	WalkForwardWindow int `yaml:"walkForwardWindow"`
+	WalkForwardStep int `yaml:"walkForwardStep"`
+	WalkForwardMode string `yaml:"walkForwardMode"`
 	LongThreshold float64 `yaml:"longThreshold"`
 	ShortThreshold float64 `yaml:"shortThreshold"`
 	RiskFreeRate float64 `yaml:"riskFreeRate"`
+	SummaryReportPath string `yaml:"summaryReportPath"`
+	Model ModelConfig `yaml:"model"`
+	Search SearchConfig `yaml:"search"`
+	Execution ExecutionConfig `yaml:"execution"`
 	Assets []Asset `yaml:"assets"`
 }
 
@@ -57,43 +58,71 @@ type Asset struct {
 }
 
 type timePriceMap map[time.Time]float64
+type timeVolumeMap map[time.Time]float64
 
 type regressionData struct {
 	cells []string
 	oosR2Score float64
+	summary AssetSummary
 }
 
 func main() {
 	configuration = commons.LoadConfiguration[Configuration]("yaml/featured.yaml")
-	analyzeData()
+	if len(os.Args) > 1 && os.Args[1] == "search" {
+		runSearch()
+	} else {
+		analyzeData()
+	}
 }
 
-func analyzeData() {
-	referenceMap := loadDailyRecords(bitcoinSymbol, nil, true, false)
-	indexMap := loadDailyRecords(configuration.IndexSymbol, nil, false, true)
-	header := []string{
-		"Symbol",
+// marketData is the price/volume history shared by every asset in a run.
+type marketData struct {
+	referenceMap timePriceMap
+	indexMap timePriceMap
+	assetMaps map[string]timePriceMap
+	volumeMaps map[string]timeVolumeMap
+	hourlyRecords map[string][]ohlc.Record
+}
+
+func loadMarketData() marketData {
+	data := marketData{
+		referenceMap: loadDailyRecords(bitcoinSymbol, nil, true, false),
+		indexMap: loadDailyRecords(configuration.IndexSymbol, nil, false, true),
+		assetMaps: map[string]timePriceMap{},
+		volumeMaps: map[string]timeVolumeMap{},
+		hourlyRecords: map[string][]ohlc.Record{},
+	}
+	for _, a := range configuration.Assets {
+		data.assetMaps[a.Symbol] = loadDailyRecords(a.Symbol, a.StartDate, true, false)
+		data.volumeMaps[a.Symbol] = loadDailyVolumes(a.Symbol, a.StartDate)
+		data.hourlyRecords[a.Symbol] = loadHourlyRecords(a.Symbol, a.StartDate)
 	}
-	if configuration.EnableMomentum {
-		header = append(header, "Momentum")
+	return data
+}
+
+func newFeatureContext(symbol string, data marketData) *FeatureContext {
+	return &FeatureContext{
+		Symbol: symbol,
+		AssetMap: data.assetMaps[symbol],
+		VolumeMap: data.volumeMaps[symbol],
+		ReferenceMap: data.referenceMap,
+		IndexMap: data.indexMap,
+		AssetMaps: data.assetMaps,
+		HourlyRecords: data.hourlyRecords[symbol],
 	}
-	if configuration.EnableReference {
-		header = append(header, "BTC")
+}
+
+func analyzeData() {
+	data := loadMarketData()
+	features, err := buildFeatures(configuration.Features)
+	if err != nil {
+		commons.Fatalf("Failed to build feature set: %v", err)
 	}
-	if configuration.EnableIndex {
-		header = append(header, configuration.IndexSymbol)
+	header := []string{
+		"Symbol",
 	}
-	if configuration.EnableWeekdays {
-		weekdays := []string{
-			"Monday",
-			"Tuesday",
-			"Wednesday",
-			"Thursday",
-			"Friday",
-			"Saturday",
-			"Sunday",
-		}
-		header = append(header, weekdays...)
+	for _, f := range features {
+		header = append(header, f.Name())
 	}
 	header = append(header, []string{
 		"Intercept",
@@ -101,18 +130,40 @@ func analyzeData() {
 		"OOS R²",
 		"Ret (Long)",
 		"SR (Long)",
+		"Sortino (Long)",
+		"Calmar (Long)",
+		"PF (Long)",
+		"Win% (Long)",
+		"AvgWin (Long)",
+		"AvgLoss (Long)",
+		"WinStreak (Long)",
+		"LossStreak (Long)",
+		"MaxDD (Long)",
 		"Ret (Short)",
 		"SR (Short)",
+		"Sortino (Short)",
+		"Calmar (Short)",
+		"PF (Short)",
+		"Win% (Short)",
+		"AvgWin (Short)",
+		"AvgLoss (Short)",
+		"WinStreak (Short)",
+		"LossStreak (Short)",
+		"MaxDD (Short)",
 	}...)
-	data := commons.ParallelMap(configuration.Assets, func (a Asset) regressionData {
-		return getRegressionCells(a.Symbol, a.StartDate, referenceMap, indexMap)
+	results := commons.ParallelMap(configuration.Assets, func (a Asset) regressionData {
+		ctx := newFeatureContext(a.Symbol, data)
+		return getRegressionCells(a.Symbol, ctx, features)
 	})
 	rows := [][]string{}
 	oosR2Scores := []float64{}
-	for _, d := range data {
+	summaries := []AssetSummary{}
+	for _, d := range results {
 		rows = append(rows, d.cells)
 		oosR2Scores = append(oosR2Scores, d.oosR2Score)
+		summaries = append(summaries, d.summary)
 	}
+	writeSummaryReport(summaries)
 	medianR2Score := commons.Median(oosR2Scores)
 	alignments := []tw.Align{
 		tw.AlignDefault,
@@ -170,98 +221,147 @@ func loadDailyRecords(symbol string, startDate *commons.SerializableDate, sessio
 	return output
 }
 
-func getRegressionCells(symbol string, startDate *commons.SerializableDate, referenceMap timePriceMap, indexMap timePriceMap) regressionData {
-	assetMap := loadDailyRecords(symbol, startDate, true, false)
-	trainingFeatures := [][]float64{}
-	trainingLabels := []float64{}
-	testFeatures := [][]float64{}
-	testLabels := []float64{}
-	for date := configuration.StartDate.Time; date.Before(configuration.EndDate.Time); date = date.AddDate(0, 0, 1) {
-		weekday := date.Weekday()
-		if configuration.EnableWeekdayFilter && weekday != configuration.WeekdayFilter.Weekday {
+// loadDailyVolumes mirrors loadDailyRecords but extracts the session-end
+// quote volume instead of the close, for volume-based features such as OBV.
+func loadDailyVolumes(symbol string, startDate *commons.SerializableDate) timeVolumeMap {
+	records := ohlc.MustReadBinance(symbol, configuration.BinanceDirectory, ohlc.TimeFrameH1)
+	output := timeVolumeMap{}
+	for _, record := range records {
+		if startDate != nil && record.Timestamp.Before(startDate.Time) {
 			continue
 		}
-		currentIndexCloseDate, currentIndexClose, exists := getClosestRecord(date, indexMap)
-		if !exists {
-			continue
+		timeOfDay := commons.GetTimeOfDay(record.Timestamp)
+		if timeOfDay == sessionEnd {
+			date := commons.GetDate(record.Timestamp)
+			output[date] = float64(record.Volume)
 		}
-		previousIndexCloseDate := currentIndexCloseDate.AddDate(0, 0, -1)
-		_, previousIndexClose, exists := getClosestRecord(previousIndexCloseDate, indexMap)
-		if !exists {
+	}
+	return output
+}
+
+// loadHourlyRecords returns an asset's raw Binance H1 history, sorted by
+// timestamp, for the execution layer's bar-by-bar exit checks.
+func loadHourlyRecords(symbol string, startDate *commons.SerializableDate) []ohlc.Record {
+	records := ohlc.MustReadBinance(symbol, configuration.BinanceDirectory, ohlc.TimeFrameH1)
+	if startDate == nil {
+		return records
+	}
+	output := []ohlc.Record{}
+	for _, record := range records {
+		if record.Timestamp.Before(startDate.Time) {
 			continue
 		}
-		currentAssetClose, exists := assetMap[date]
-		if !exists {
+		output = append(output, record)
+	}
+	return output
+}
+
+// sample is a single dated training/evaluation row, i.e. one set of features
+// together with the label they are meant to predict.
+type sample struct {
+	date time.Time
+	features []float64
+	label float64
+}
+
+// buildSamples assembles one sample per day in [startDate, endDate) for
+// which every feature and the forward label are available.
+func buildSamples(ctx *FeatureContext, features []Feature, holdingTime int, weekdayFilter *commons.SerializableWeekday, startDate time.Time, endDate time.Time) []sample {
+	samples := []sample{}
+	for date := startDate; date.Before(endDate); date = date.AddDate(0, 0, 1) {
+		if weekdayFilter != nil && date.Weekday() != weekdayFilter.Weekday {
 			continue
 		}
-		previousDate := date.AddDate(0, 0, -1)
-		previousAssetClose, exists := assetMap[previousDate]
+		currentAssetClose, exists := ctx.AssetMap[date]
 		if !exists {
 			continue
 		}
-		nextCloseTimestamp := date.AddDate(0, 0, configuration.HoldingTime)
-		nextAssetClose, exists := assetMap[nextCloseTimestamp]
+		nextAssetClose, exists := ctx.AssetMap[date.AddDate(0, 0, holdingTime)]
 		if !exists {
 			continue
 		}
-		currentReferenceClose, exists := referenceMap[date]
-		if !exists {
-			continue
+		dailyFeatures := make([]float64, len(features))
+		complete := true
+		for i, f := range features {
+			value, ok := f.Compute(date, ctx)
+			if !ok {
+				complete = false
+				break
+			}
+			dailyFeatures[i] = value
 		}
-		previousReferenceClose, exists := referenceMap[previousDate]
-		if !exists {
+		if !complete {
 			continue
 		}
-		assetMomentum := getRateOfChange(currentAssetClose, previousAssetClose)
-		var referenceMomentum float64
-		if symbol != bitcoinSymbol {
-			referenceMomentum = getRateOfChange(currentReferenceClose, previousReferenceClose)
-		} else {
-			referenceMomentum = 0.0
-		}
-		indexMomentum := getRateOfChange(currentIndexClose, previousIndexClose)
-		dailyFeatures := []float64{}
-		if configuration.EnableMomentum {
-			dailyFeatures = append(dailyFeatures, assetMomentum)
-		}
-		if configuration.EnableReference {
-			dailyFeatures = append(dailyFeatures, referenceMomentum)
-		}
-		if configuration.EnableIndex {
-			dailyFeatures = append(dailyFeatures, indexMomentum)
-		}
-		if configuration.EnableWeekdays {
-			weekdayIndex := (int(weekday) + 6) % daysPerWeek
-			for j := range daysPerWeek {
-				var value float64
-				if j == weekdayIndex {
-					value = 1.0
-				} else {
-					value = 0.0
-				}
-				dailyFeatures = append(dailyFeatures, value)
+		label := getRateOfChange(nextAssetClose, currentAssetClose)
+		samples = append(samples, sample{
+			date: date,
+			features: dailyFeatures,
+			label: label,
+		})
+	}
+	return samples
+}
+
+func getRegressionCells(symbol string, ctx *FeatureContext, features []Feature) regressionData {
+	var weekdayFilter *commons.SerializableWeekday
+	if configuration.EnableWeekdayFilter {
+		weekdayFilter = &configuration.WeekdayFilter
+	}
+	samples := buildSamples(ctx, features, configuration.HoldingTime, weekdayFilter, configuration.StartDate.Time, configuration.EndDate.Time)
+	var model Model
+	var trainingFeatures, testFeatures [][]float64
+	var trainingLabels, testLabels []float64
+	var testDates []time.Time
+	var isR2Score, oosR2Score float64
+	var oosSignals []float64
+	var foldR2Scores []float64
+	if configuration.WalkForwardWindow > 0 {
+		result := runWalkForward(symbol, samples)
+		model = result.model
+		trainingFeatures = result.trainingFeatures
+		trainingLabels = result.trainingLabels
+		testFeatures = result.oosFeatures
+		testLabels = result.oosLabels
+		testDates = result.oosDates
+		oosSignals = result.oosSignals
+		foldR2Scores = result.foldR2Scores
+		isR2Score = getR2Score(trainingFeatures, trainingLabels, model)
+		oosR2Score = result.oosR2Score
+	} else {
+		for _, s := range samples {
+			if s.date.Before(configuration.SplitDate.Time) {
+				trainingFeatures = append(trainingFeatures, s.features)
+				trainingLabels = append(trainingLabels, s.label)
+			} else {
+				testFeatures = append(testFeatures, s.features)
+				testLabels = append(testLabels, s.label)
+				testDates = append(testDates, s.date)
 			}
 		}
-		label := getRateOfChange(nextAssetClose, currentAssetClose)
-		if date.Before(configuration.SplitDate.Time) {
-			trainingFeatures = append(trainingFeatures, dailyFeatures)
-			trainingLabels = append(trainingLabels, label)
-		} else {
-			testFeatures = append(testFeatures, dailyFeatures)
-			testLabels = append(testLabels, label)
+		var err error
+		model, err = newModel(configuration.Model)
+		if err != nil {
+			commons.Fatalf("Failed to create model: %v", err)
 		}
-	}
-	model := linear.NewLeastSquares(logisticMethod, alpha, regularization, maxIterations, trainingFeatures, trainingLabels)
-	model.Output = io.Discard
-	err := model.Learn()
-	if err != nil {
-		commons.Fatalf("Failed to fit model: %v", err)
+		err = model.Fit(trainingFeatures, trainingLabels)
+		if err != nil {
+			commons.Fatalf("Failed to fit model: %v", err)
+		}
+		isR2Score = getR2Score(trainingFeatures, trainingLabels, model)
+		oosR2Score = getR2Score(testFeatures, testLabels, model)
+		oosSignals = getSignals(testFeatures, model)
 	}
 	cells := []string{
 		commons.White(symbol),
 	}
+	coefficients, coefficientsOk := model.Coefficients()
 	addParameter := func (index int) {
-		parameter := model.Parameters[index]
+		if !coefficientsOk {
+			cells = append(cells, "-")
+			return
+		}
+		parameter := coefficients[index]
 		var cell string
 		if parameter != 0.0 {
 			cell = fmt.Sprintf("%.4f", parameter)
@@ -275,39 +375,62 @@ func getRegressionCells(symbol string, startDate *commons.SerializableDate, refe
 		}
 		cells = append(cells, cell)
 	}
-	for j := 1; j < len(model.Parameters); j++ {
+	featureCount := 0
+	if len(trainingFeatures) > 0 {
+		featureCount = len(trainingFeatures[0])
+	}
+	for j := 1; j <= featureCount; j++ {
 		addParameter(j)
 	}
 	addParameter(0)
+	var importances []float64
+	if importanceModel, ok := model.(ImportanceModel); ok {
+		importances = importanceModel.Importances()
+	}
 	addR2Score := func (r2Score float64) {
 		cell := commons.FormatPercentage(r2Score, 2)
 		cells = append(cells, cell)
 	}
-	isR2Score := getR2Score(trainingFeatures, trainingLabels, model)
 	addR2Score(isR2Score)
-	oosR2Score := getR2Score(testFeatures, testLabels, model)
 	addR2Score(oosR2Score)
-	longReturns, shortReturns := runBacktest(testFeatures, testLabels, model)
-	addReturns := func (returns []float64) {
-		totalReturn, sharpeRatio := analyzeReturns(returns)
-		var totalReturnString, sharpeRatioString string
-		if totalReturn != 0.0 {
-			totalReturnString = commons.FormatPercentage(totalReturn, 2)
-			sharpeRatioString = fmt.Sprintf("%.2f", sharpeRatio)
-		} else {
-			totalReturnString = "-"
-			sharpeRatioString = "-"
+	longReturns, shortReturns := runBacktestFromSignals(ctx, testDates, oosSignals, configuration.HoldingTime, configuration.LongThreshold, configuration.ShortThreshold)
+	addReturns := func (returns []float64) TradeStats {
+		stats := analyzeReturns(returns)
+		if stats.TotalReturn == 0.0 {
+			cells = append(cells, []string{
+				"-", "-", "-", "-", "-", "-", "-", "-", "-", "-", "-",
+			}...)
+			return stats
 		}
 		cells = append(cells, []string{
-			totalReturnString,
-			sharpeRatioString,
+			commons.FormatPercentage(stats.TotalReturn, 2),
+			formatRatio(stats.SharpeRatio),
+			formatRatio(stats.SortinoRatio),
+			formatRatio(stats.CalmarRatio),
+			formatRatio(stats.ProfitFactor),
+			formatPercentageOrDash(stats.WinRatio),
+			formatPercentageOrDash(stats.AverageWin),
+			formatPercentageOrDash(stats.AverageLoss),
+			fmt.Sprintf("%d", stats.LongestWinStreak),
+			fmt.Sprintf("%d", stats.LongestLossStreak),
+			commons.FormatPercentage(stats.MaxDrawdown, 2),
 		}...)
+		return stats
 	}
-	addReturns(longReturns)
-	addReturns(shortReturns)
+	longStats := addReturns(longReturns)
+	shortStats := addReturns(shortReturns)
 	data := regressionData{
 		cells: cells,
 		oosR2Score: oosR2Score,
+		summary: AssetSummary{
+			Symbol: symbol,
+			IsR2Score: isR2Score,
+			OosR2Score: oosR2Score,
+			FoldR2Scores: foldR2Scores,
+			Importances: importances,
+			Long: longStats,
+			Short: shortStats,
+		},
 	}
 	return data
 }
@@ -316,19 +439,50 @@ func getRateOfChange(a, b float64) float64 {
 	return a / b - 1.0
 }
 
-func getR2Score(features [][]float64, labels []float64, model *linear.LeastSquares) float64 {
-	meanObserved := commons.Mean(labels)
-	residualSum := 0.0
-	totalSum := 0.0
+// formatRatio renders a dimensionless stat (Sharpe/Sortino/Calmar/profit
+// factor) to two decimals, degrading to "-" when it's undefined (e.g. a
+// Calmar ratio with zero drawdown).
+func formatRatio(ratio float64) string {
+	if math.IsNaN(ratio) {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", ratio)
+}
+
+// formatPercentageOrDash is commons.FormatPercentage with a "-" fallback for
+// undefined stats, e.g. a win ratio with no non-zero returns.
+func formatPercentageOrDash(value float64) string {
+	if math.IsNaN(value) {
+		return "-"
+	}
+	return commons.FormatPercentage(value, 2)
+}
+
+func getSignals(features [][]float64, model Model) []float64 {
+	signals := make([]float64, len(features))
 	for i := range features {
-		label := labels[i]
-		prediction, err := model.Predict(features[i])
+		signal, err := model.Predict(features[i])
 		if err != nil {
 			commons.Fatalf("Prediction failed: %v", err)
 		}
-		residualDelta := label - prediction[0]
+		signals[i] = signal
+	}
+	return signals
+}
+
+func getR2Score(features [][]float64, labels []float64, model Model) float64 {
+	signals := getSignals(features, model)
+	return getR2ScoreFromSignals(labels, signals)
+}
+
+func getR2ScoreFromSignals(labels []float64, signals []float64) float64 {
+	meanObserved := commons.Mean(labels)
+	residualSum := 0.0
+	totalSum := 0.0
+	for i := range labels {
+		residualDelta := labels[i] - signals[i]
 		residualSum += residualDelta * residualDelta
-		totalDelta := label - meanObserved
+		totalDelta := labels[i] - meanObserved
 		totalSum += totalDelta * totalDelta
 	}
 	r2Score := 1.0 - residualSum / totalSum
@@ -345,53 +499,3 @@ func getClosestRecord(date time.Time, indexMap timePriceMap) (time.Time, float64
 	}
 	return time.Time{}, math.NaN(), false
 }
-
-func runBacktest(features [][]float64, labels []float64, model *linear.LeastSquares) ([]float64, []float64) {
-	longReturns := []float64{}
-	shortReturns := []float64{}
-	for i := range features {
-		prediction, err := model.Predict(features[i])
-		if err != nil {
-			commons.Fatalf("Prediction failed: %v", err)
-		}
-		signal := prediction[0]
-		label := labels[i]
-		// fmt.Printf("signal = %.3f, label = %.3f\n", signal, label)
-		if signal > configuration.LongThreshold {
-			longReturns = append(longReturns, label)
-		} else {
-			longReturns = append(longReturns, 0.0)
-		}
-		if signal < configuration.ShortThreshold {
-			shortReturn := 1.0 / (1.0 + label) - 1.0
-			shortReturns = append(shortReturns, shortReturn)
-		} else {
-			shortReturns = append(shortReturns, 0.0)
-		}
-	}
-	return longReturns, shortReturns
-}
-
-func analyzeReturns(returns []float64) (float64, float64) {
-	totalReturn := 0.0
-	for _, r := range returns {
-		totalReturn += r
-	}
-	sharpeRatio := getSharpeRatio(returns)
-	return totalReturn, sharpeRatio
-}
-
-func getSharpeRatio(weeklyReturns []float64) float64 {
-	if len(weeklyReturns) < 2 {
-		return math.NaN()
-	}
-	meanReturn := commons.Mean(weeklyReturns)
-	stdDev := commons.StdDev(weeklyReturns)
-	riskFreeRate := configuration.RiskFreeRate / weeksPerYear
-	weeklySharpeRatio := (meanReturn - riskFreeRate) / stdDev
-	sharpeRatio := math.Sqrt(weeksPerYear) * weeklySharpeRatio
-	if math.IsInf(sharpeRatio, 1) || math.IsInf(sharpeRatio, -1) {
-		return math.NaN()
-	}
-	return sharpeRatio
-}
\ No newline at end of file
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/cdipaolo/goml/linear"
+)
+
+const (
+	modelKindLinear = "linear"
+	modelKindRidge = "ridge"
+	modelKindLasso = "lasso"
+	modelKindElasticNet = "elasticnet"
+	modelKindLogistic = "logistic"
+	// modelKindGbm is recognized but not implemented: no Go gradient
+	// boosting library is vendored, so it is out of scope for this
+	// backlog item until one is wired up. See newModel.
+	modelKindGbm = "gbm"
+
+	coordinateDescentIterations = 200
+)
+
+// ModelConfig selects a Model implementation and its hyperparameters from
+// YAML, e.g. "model: {kind: ridge, params: {lambda: 0.1}}".
+type ModelConfig struct {
+	Kind string `yaml:"kind"`
+	Params map[string]float64 `yaml:"params"`
+}
+
+// Model abstracts over the regression/classification backend used to turn
+// features into a trading signal, so getRegressionCells and runWalkForward
+// don't have to know which backend is in play.
+type Model interface {
+	Fit(features [][]float64, labels []float64) error
+	Predict(features []float64) (float64, error)
+	// Coefficients returns the model's linear coefficients with the
+	// intercept at index 0, or ok=false if the model has no interpretable
+	// coefficients (e.g. a tree ensemble).
+	Coefficients() (coefficients []float64, ok bool)
+}
+
+// ImportanceModel is implemented by Models that can rank features by
+// importance even though they have no linear coefficients to report.
+type ImportanceModel interface {
+	Importances() []float64
+}
+
+func newModel(config ModelConfig) (Model, error) {
+	kind := config.Kind
+	if kind == "" {
+		kind = modelKindLinear
+	}
+	switch kind {
+	case modelKindLinear:
+		return &leastSquaresModel{config: config}, nil
+	case modelKindRidge:
+		return &ridgeModel{l2: config.Params["lambda"]}, nil
+	case modelKindLasso:
+		return &lassoModel{l1: config.Params["lambda"]}, nil
+	case modelKindElasticNet:
+		return &elasticNetModel{l1: config.Params["l1"], l2: config.Params["l2"]}, nil
+	case modelKindLogistic:
+		return &logisticModel{config: config}, nil
+	case modelKindGbm:
+		return nil, fmt.Errorf("model kind \"gbm\" is not implemented (no Go gradient boosting library is vendored); scoped out of this backlog item until a real backend is added")
+	default:
+		return nil, fmt.Errorf("unknown model kind \"%s\"", kind)
+	}
+}
+
+// getGomlParams resolves the alpha/regularization hyperparameters for the
+// goml-backed models, falling back to the package defaults when the model
+// config doesn't override them (e.g. outside of a grid search).
+func getGomlParams(config ModelConfig) (float64, float64) {
+	modelAlpha := float64(alpha)
+	if value, ok := config.Params["alpha"]; ok {
+		modelAlpha = value
+	}
+	modelRegularization := float64(regularization)
+	if value, ok := config.Params["regularization"]; ok {
+		modelRegularization = value
+	}
+	return modelAlpha, modelRegularization
+}
+
+// leastSquaresModel is the original goml ordinary least squares backend.
+type leastSquaresModel struct {
+	config ModelConfig
+	model *linear.LeastSquares
+}
+
+func (m *leastSquaresModel) Fit(features [][]float64, labels []float64) error {
+	modelAlpha, modelRegularization := getGomlParams(m.config)
+	m.model = linear.NewLeastSquares(logisticMethod, modelAlpha, modelRegularization, maxIterations, features, labels)
+	m.model.Output = io.Discard
+	return m.model.Learn()
+}
+
+func (m *leastSquaresModel) Predict(features []float64) (float64, error) {
+	prediction, err := m.model.Predict(features)
+	if err != nil {
+		return 0.0, err
+	}
+	return prediction[0], nil
+}
+
+func (m *leastSquaresModel) Coefficients() ([]float64, bool) {
+	return m.model.Parameters, true
+}
+
+// logisticModel predicts the probability that the label's sign is positive
+// and lets the long/short thresholds act on that probability directly.
+type logisticModel struct {
+	config ModelConfig
+	model *linear.Logistic
+}
+
+func (m *logisticModel) Fit(features [][]float64, labels []float64) error {
+	classes := make([]float64, len(labels))
+	for i, label := range labels {
+		if label > 0.0 {
+			classes[i] = 1.0
+		}
+	}
+	modelAlpha, modelRegularization := getGomlParams(m.config)
+	m.model = linear.NewLogistic(logisticMethod, modelAlpha, modelRegularization, maxIterations, features, classes)
+	m.model.Output = io.Discard
+	return m.model.Learn()
+}
+
+func (m *logisticModel) Predict(features []float64) (float64, error) {
+	prediction, err := m.model.Predict(features)
+	if err != nil {
+		return 0.0, err
+	}
+	return prediction[0], nil
+}
+
+func (m *logisticModel) Coefficients() ([]float64, bool) {
+	return m.model.Parameters, true
+}
+
+// ridgeModel is a hand-rolled coordinate descent solver with an L2 penalty.
+type ridgeModel struct {
+	l2 float64
+	weights []float64
+}
+
+func (m *ridgeModel) Fit(features [][]float64, labels []float64) error {
+	m.weights = fitCoordinateDescent(features, labels, 0.0, m.l2, coordinateDescentIterations)
+	return nil
+}
+
+func (m *ridgeModel) Predict(features []float64) (float64, error) {
+	return predictWeights(m.weights, features), nil
+}
+
+func (m *ridgeModel) Coefficients() ([]float64, bool) {
+	return m.weights, true
+}
+
+// lassoModel is a hand-rolled coordinate descent solver with an L1 penalty.
+type lassoModel struct {
+	l1 float64
+	weights []float64
+}
+
+func (m *lassoModel) Fit(features [][]float64, labels []float64) error {
+	m.weights = fitCoordinateDescent(features, labels, m.l1, 0.0, coordinateDescentIterations)
+	return nil
+}
+
+func (m *lassoModel) Predict(features []float64) (float64, error) {
+	return predictWeights(m.weights, features), nil
+}
+
+func (m *lassoModel) Coefficients() ([]float64, bool) {
+	return m.weights, true
+}
+
+// elasticNetModel combines both penalties.
+type elasticNetModel struct {
+	l1 float64
+	l2 float64
+	weights []float64
+}
+
+func (m *elasticNetModel) Fit(features [][]float64, labels []float64) error {
+	m.weights = fitCoordinateDescent(features, labels, m.l1, m.l2, coordinateDescentIterations)
+	return nil
+}
+
+func (m *elasticNetModel) Predict(features []float64) (float64, error) {
+	return predictWeights(m.weights, features), nil
+}
+
+func (m *elasticNetModel) Coefficients() ([]float64, bool) {
+	return m.weights, true
+}
+
+// fitCoordinateDescent fits an intercept plus one weight per feature
+// (weights[0] is the intercept) by cyclic coordinate descent, soft
+// thresholding each update by l1 and shrinking it by l2.
+func fitCoordinateDescent(features [][]float64, labels []float64, l1 float64, l2 float64, iterations int) []float64 {
+	sampleCount := len(features)
+	if sampleCount == 0 {
+		return []float64{0.0}
+	}
+	featureCount := len(features[0])
+	weights := make([]float64, featureCount + 1)
+	for range iterations {
+		residualSum := 0.0
+		for i := range features {
+			residualSum += labels[i] - predictWeights(weights, features[i]) + weights[0]
+		}
+		weights[0] = residualSum / float64(sampleCount)
+		for j := range featureCount {
+			numerator := 0.0
+			denominator := 0.0
+			for i := range features {
+				x := features[i][j]
+				predictionWithoutJ := predictWeights(weights, features[i]) - weights[j + 1] * x
+				residual := labels[i] - predictionWithoutJ
+				numerator += x * residual
+				denominator += x * x
+			}
+			weights[j + 1] = softThreshold(numerator, l1) / (denominator + l2)
+		}
+	}
+	return weights
+}
+
+func predictWeights(weights []float64, features []float64) float64 {
+	sum := weights[0]
+	for j, x := range features {
+		sum += weights[j + 1] * x
+	}
+	return sum
+}
+
+func softThreshold(value float64, threshold float64) float64 {
+	switch {
+	case value > threshold:
+		return value - threshold
+	case value < - threshold:
+		return value + threshold
+	default:
+		return 0.0
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/encratite/commons"
+	"github.com/olekukonko/tablewriter"
+	"github.com/olekukonko/tablewriter/tw"
+)
+
+// SearchConfig configures the "search" subcommand's hyperparameter grid.
+// Any dimension left empty falls back to the corresponding top-level
+// Configuration value, so a partial grid only sweeps what it names.
+type SearchConfig struct {
+	HoldingTimes []int `yaml:"holdingTimes"`
+	LongThresholds []float64 `yaml:"longThresholds"`
+	ShortThresholds []float64 `yaml:"shortThresholds"`
+	Alphas []float64 `yaml:"alphas"`
+	Regularizations []float64 `yaml:"regularizations"`
+	WeekdayFilters []commons.SerializableWeekday `yaml:"weekdayFilters"`
+	FeatureSets [][]FeatureConfig `yaml:"featureSets"`
+	// ValidationSplit is the fraction of [StartDate, SplitDate) held out as
+	// a validation slice used to score candidates.
+	ValidationSplit float64 `yaml:"validationSplit"`
+	// Objective is "sharpe", "calmar", or "r2".
+	Objective string `yaml:"objective"`
+	TopK int `yaml:"topK"`
+}
+
+// searchCandidate is a single point in the hyperparameter grid.
+type searchCandidate struct {
+	index int
+	holdingTime int
+	longThreshold float64
+	shortThreshold float64
+	modelConfig ModelConfig
+	weekdayFilter *commons.SerializableWeekday
+	featureSetIndex int
+	features []Feature
+}
+
+// searchResult is a scored candidate for one asset.
+type searchResult struct {
+	candidate searchCandidate
+	validationObjective float64
+	oosR2Score float64
+	oosLong TradeStats
+	oosShort TradeStats
+}
+
+func runSearch() {
+	data := loadMarketData()
+	candidates, err := buildSearchCandidates(configuration.Search)
+	if err != nil {
+		commons.Fatalf("Failed to build search grid: %v", err)
+	}
+	validationSplit := configuration.Search.ValidationSplit
+	if validationSplit <= 0.0 || validationSplit >= 1.0 {
+		validationSplit = 0.2
+	}
+	trainStart := configuration.StartDate.Time
+	splitDate := configuration.SplitDate.Time
+	trainingDays := int(splitDate.Sub(trainStart).Hours() / 24)
+	validationDate := trainStart.AddDate(0, 0, trainingDays - int(float64(trainingDays) * validationSplit))
+	topK := configuration.Search.TopK
+	if topK <= 0 {
+		topK = 5
+	}
+	assetResults := commons.ParallelMap(configuration.Assets, func (a Asset) []searchResult {
+		ctx := newFeatureContext(a.Symbol, data)
+		results := make([]searchResult, len(candidates))
+		for i, candidate := range candidates {
+			results[i] = evaluateCandidate(ctx, candidate, trainStart, validationDate, splitDate, configuration.EndDate.Time)
+		}
+		sort.Slice(results, func (i, j int) bool {
+			return objectiveRank(results[i].validationObjective) > objectiveRank(results[j].validationObjective)
+		})
+		return results
+	})
+	aggregateObjectives := make([]float64, len(candidates))
+	for i := range candidates {
+		perAsset := make([]float64, len(assetResults))
+		for a, results := range assetResults {
+			for _, r := range results {
+				if r.candidate.index == candidates[i].index {
+					perAsset[a] = r.validationObjective
+				}
+			}
+		}
+		aggregateObjectives[i] = commons.Median(perAsset)
+	}
+	winnerIndex := 0
+	for i := range candidates {
+		if objectiveRank(aggregateObjectives[i]) > objectiveRank(aggregateObjectives[winnerIndex]) {
+			winnerIndex = i
+		}
+	}
+	for i, a := range configuration.Assets {
+		printSearchTable(a.Symbol, assetResults[i], topK)
+	}
+	fmt.Printf("Aggregate winner (median %s across assets): %s\n\n", configuration.Search.Objective, describeCandidate(candidates[winnerIndex]))
+}
+
+func printSearchTable(symbol string, results []searchResult, topK int) {
+	header := []string{
+		"Rank",
+		"Holding",
+		"Long",
+		"Short",
+		"Features",
+		"Objective",
+		"OOS R²",
+	}
+	rows := [][]string{}
+	limit := min(topK, len(results))
+	for i := 0; i < limit; i++ {
+		r := results[i]
+		rows = append(rows, []string{
+			fmt.Sprintf("%d", i + 1),
+			fmt.Sprintf("%d", r.candidate.holdingTime),
+			fmt.Sprintf("%.4f", r.candidate.longThreshold),
+			fmt.Sprintf("%.4f", r.candidate.shortThreshold),
+			fmt.Sprintf("%d", r.candidate.featureSetIndex),
+			fmt.Sprintf("%.4f", r.validationObjective),
+			commons.FormatPercentage(r.oosR2Score, 2),
+		})
+	}
+	fmt.Printf("\n%s\n", commons.White(symbol))
+	table := tablewriter.NewTable(os.Stdout, tablewriter.WithConfig(tablewriter.Config{
+		Header: tw.CellConfig{
+			Formatting: tw.CellFormatting{AutoFormat: tw.Off},
+			Alignment: tw.CellAlignment{Global: tw.AlignLeft},
+		},
+	}))
+	table.Header(header)
+	table.Bulk(rows)
+	table.Render()
+}
+
+func describeCandidate(c searchCandidate) string {
+	return fmt.Sprintf("holdingTime=%d longThreshold=%.4f shortThreshold=%.4f featureSet=%d", c.holdingTime, c.longThreshold, c.shortThreshold, c.featureSetIndex)
+}
+
+// evaluateCandidate fits the candidate on [trainStart, validationDate),
+// scores it on the [validationDate, splitDate) validation slice, then
+// refits on the full training window [trainStart, splitDate) and reports
+// performance on the untouched [splitDate, endDate) OOS window.
+func evaluateCandidate(ctx *FeatureContext, candidate searchCandidate, trainStart time.Time, validationDate time.Time, splitDate time.Time, endDate time.Time) searchResult {
+	validationSamples := buildSamples(ctx, candidate.features, candidate.holdingTime, candidate.weekdayFilter, trainStart, splitDate)
+	var trainingFeatures, validationFeatures [][]float64
+	var trainingLabels, validationLabels []float64
+	var validationDates []time.Time
+	for _, s := range validationSamples {
+		if s.date.Before(validationDate) {
+			trainingFeatures = append(trainingFeatures, s.features)
+			trainingLabels = append(trainingLabels, s.label)
+		} else {
+			validationFeatures = append(validationFeatures, s.features)
+			validationLabels = append(validationLabels, s.label)
+			validationDates = append(validationDates, s.date)
+		}
+	}
+	validationObjective := 0.0
+	validationModel, err := newModel(candidate.modelConfig)
+	if err == nil && len(trainingFeatures) > 0 {
+		err = validationModel.Fit(trainingFeatures, trainingLabels)
+		if err == nil {
+			r2Score := getR2Score(validationFeatures, validationLabels, validationModel)
+			signals := getSignals(validationFeatures, validationModel)
+			longReturns, _ := runBacktestFromSignals(ctx, validationDates, signals, candidate.holdingTime, candidate.longThreshold, candidate.shortThreshold)
+			longStats := analyzeReturns(longReturns)
+			validationObjective = getObjectiveValue(configuration.Search.Objective, r2Score, longStats)
+		}
+	}
+	oosSamples := buildSamples(ctx, candidate.features, candidate.holdingTime, candidate.weekdayFilter, trainStart, endDate)
+	var fullTrainingFeatures, oosFeatures [][]float64
+	var fullTrainingLabels, oosLabels []float64
+	var oosDates []time.Time
+	for _, s := range oosSamples {
+		if s.date.Before(splitDate) {
+			fullTrainingFeatures = append(fullTrainingFeatures, s.features)
+			fullTrainingLabels = append(fullTrainingLabels, s.label)
+		} else {
+			oosFeatures = append(oosFeatures, s.features)
+			oosLabels = append(oosLabels, s.label)
+			oosDates = append(oosDates, s.date)
+		}
+	}
+	result := searchResult{candidate: candidate, validationObjective: validationObjective}
+	oosModel, err := newModel(candidate.modelConfig)
+	if err != nil || len(fullTrainingFeatures) == 0 {
+		return result
+	}
+	err = oosModel.Fit(fullTrainingFeatures, fullTrainingLabels)
+	if err != nil {
+		return result
+	}
+	result.oosR2Score = getR2Score(oosFeatures, oosLabels, oosModel)
+	oosSignals := getSignals(oosFeatures, oosModel)
+	longReturns, shortReturns := runBacktestFromSignals(ctx, oosDates, oosSignals, candidate.holdingTime, candidate.longThreshold, candidate.shortThreshold)
+	result.oosLong = analyzeReturns(longReturns)
+	result.oosShort = analyzeReturns(shortReturns)
+	return result
+}
+
+// objectiveRank maps an objective value to something safe to compare with
+// `>`, since Go's NaN comparisons are always false and would otherwise let a
+// NaN objective (e.g. a Sharpe ratio with <2 non-zero validation returns)
+// silently win a sort or a max-search against any real value.
+func objectiveRank(value float64) float64 {
+	if math.IsNaN(value) {
+		return math.Inf(-1)
+	}
+	return value
+}
+
+func getObjectiveValue(objective string, r2Score float64, longStats TradeStats) float64 {
+	switch objective {
+	case "calmar":
+		return longStats.CalmarRatio
+	case "r2":
+		return r2Score
+	default:
+		return longStats.SharpeRatio
+	}
+}
+
+func buildSearchCandidates(search SearchConfig) ([]searchCandidate, error) {
+	holdingTimes := search.HoldingTimes
+	if len(holdingTimes) == 0 {
+		holdingTimes = []int{configuration.HoldingTime}
+	}
+	longThresholds := search.LongThresholds
+	if len(longThresholds) == 0 {
+		longThresholds = []float64{configuration.LongThreshold}
+	}
+	shortThresholds := search.ShortThresholds
+	if len(shortThresholds) == 0 {
+		shortThresholds = []float64{configuration.ShortThreshold}
+	}
+	modelAlpha, modelRegularization := getGomlParams(configuration.Model)
+	alphas := search.Alphas
+	if len(alphas) == 0 {
+		alphas = []float64{modelAlpha}
+	}
+	regularizations := search.Regularizations
+	if len(regularizations) == 0 {
+		regularizations = []float64{modelRegularization}
+	}
+	weekdayFilters := []*commons.SerializableWeekday{nil}
+	if len(search.WeekdayFilters) > 0 {
+		weekdayFilters = make([]*commons.SerializableWeekday, len(search.WeekdayFilters))
+		for i := range search.WeekdayFilters {
+			weekdayFilters[i] = &search.WeekdayFilters[i]
+		}
+	} else if configuration.EnableWeekdayFilter {
+		weekdayFilters = []*commons.SerializableWeekday{&configuration.WeekdayFilter}
+	}
+	featureSetConfigs := search.FeatureSets
+	if len(featureSetConfigs) == 0 {
+		featureSetConfigs = [][]FeatureConfig{configuration.Features}
+	}
+	featureSets := make([][]Feature, len(featureSetConfigs))
+	for i, config := range featureSetConfigs {
+		features, err := buildFeatures(config)
+		if err != nil {
+			return nil, err
+		}
+		featureSets[i] = features
+	}
+	candidates := []searchCandidate{}
+	for featureSetIndex, features := range featureSets {
+		for _, holdingTime := range holdingTimes {
+			for _, longThreshold := range longThresholds {
+				for _, shortThreshold := range shortThresholds {
+					for _, alphaValue := range alphas {
+						for _, regularizationValue := range regularizations {
+							for _, weekdayFilter := range weekdayFilters {
+								params := map[string]float64{}
+								for key, value := range configuration.Model.Params {
+									params[key] = value
+								}
+								params["alpha"] = alphaValue
+								params["regularization"] = regularizationValue
+								modelConfig := ModelConfig{
+									Kind: configuration.Model.Kind,
+									Params: params,
+								}
+								candidates = append(candidates, searchCandidate{
+									index: len(candidates),
+									holdingTime: holdingTime,
+									longThreshold: longThreshold,
+									shortThreshold: shortThreshold,
+									modelConfig: modelConfig,
+									weekdayFilter: weekdayFilter,
+									featureSetIndex: featureSetIndex,
+									features: features,
+								})
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return candidates, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"time"
+
+	"github.com/encratite/commons"
+)
+
+// TradeStats holds the full set of per-strategy performance metrics computed
+// from a series of per-trade returns.
+type TradeStats struct {
+	TotalReturn float64 `json:"totalReturn"`
+	SharpeRatio float64 `json:"sharpeRatio"`
+	SortinoRatio float64 `json:"sortinoRatio"`
+	CalmarRatio float64 `json:"calmarRatio"`
+	ProfitFactor float64 `json:"profitFactor"`
+	WinRatio float64 `json:"winRatio"`
+	AverageWin float64 `json:"averageWin"`
+	AverageLoss float64 `json:"averageLoss"`
+	LongestWinStreak int `json:"longestWinStreak"`
+	LongestLossStreak int `json:"longestLossStreak"`
+	MaxDrawdown float64 `json:"maxDrawdown"`
+}
+
+// AssetSummary is the per-asset slice of a SummaryReport.
+type AssetSummary struct {
+	Symbol string `json:"symbol"`
+	IsR2Score float64 `json:"isR2Score"`
+	OosR2Score float64 `json:"oosR2Score"`
+	FoldR2Scores []float64 `json:"foldR2Scores,omitempty"`
+	Importances []float64 `json:"importances,omitempty"`
+	Long TradeStats `json:"long"`
+	Short TradeStats `json:"short"`
+}
+
+// SummaryReport captures the outcome of a full run so that separate runs can
+// be diffed against each other.
+type SummaryReport struct {
+	GeneratedAt time.Time `json:"generatedAt"`
+	StartDate time.Time `json:"startDate"`
+	SplitDate time.Time `json:"splitDate"`
+	EndDate time.Time `json:"endDate"`
+	Assets []AssetSummary `json:"assets"`
+}
+
+func analyzeReturns(returns []float64) TradeStats {
+	totalReturn := 0.0
+	wins := []float64{}
+	losses := []float64{}
+	winStreak := 0
+	lossStreak := 0
+	longestWinStreak := 0
+	longestLossStreak := 0
+	for _, r := range returns {
+		totalReturn += r
+		switch {
+		case r > 0.0:
+			wins = append(wins, r)
+			winStreak++
+			lossStreak = 0
+		case r < 0.0:
+			losses = append(losses, r)
+			lossStreak++
+			winStreak = 0
+		default:
+			winStreak = 0
+			lossStreak = 0
+		}
+		longestWinStreak = max(longestWinStreak, winStreak)
+		longestLossStreak = max(longestLossStreak, lossStreak)
+	}
+	nonZeroCount := len(wins) + len(losses)
+	winRatio := math.NaN()
+	if nonZeroCount > 0 {
+		winRatio = float64(len(wins)) / float64(nonZeroCount)
+	}
+	stats := TradeStats{
+		TotalReturn: totalReturn,
+		SharpeRatio: getSharpeRatio(returns),
+		SortinoRatio: getSortinoRatio(returns),
+		ProfitFactor: getProfitFactor(wins, losses),
+		WinRatio: winRatio,
+		AverageWin: commons.Mean(wins),
+		AverageLoss: commons.Mean(losses),
+		LongestWinStreak: longestWinStreak,
+		LongestLossStreak: longestLossStreak,
+		MaxDrawdown: getMaxDrawdown(returns),
+	}
+	stats.CalmarRatio = getCalmarRatio(stats.TotalReturn, stats.MaxDrawdown, len(returns))
+	return stats
+}
+
+func getSharpeRatio(weeklyReturns []float64) float64 {
+	if len(weeklyReturns) < 2 {
+		return math.NaN()
+	}
+	meanReturn := commons.Mean(weeklyReturns)
+	stdDev := commons.StdDev(weeklyReturns)
+	riskFreeRate := configuration.RiskFreeRate / weeksPerYear
+	weeklySharpeRatio := (meanReturn - riskFreeRate) / stdDev
+	sharpeRatio := math.Sqrt(weeksPerYear) * weeklySharpeRatio
+	if math.IsInf(sharpeRatio, 1) || math.IsInf(sharpeRatio, -1) {
+		return math.NaN()
+	}
+	return sharpeRatio
+}
+
+// getSortinoRatio is the Sharpe-like ratio that only penalizes downside
+// volatility, i.e. the standard deviation of negative returns.
+func getSortinoRatio(returns []float64) float64 {
+	if len(returns) < 2 {
+		return math.NaN()
+	}
+	downside := []float64{}
+	for _, r := range returns {
+		if r < 0.0 {
+			downside = append(downside, r)
+		}
+	}
+	if len(downside) < 2 {
+		return math.NaN()
+	}
+	meanReturn := commons.Mean(returns)
+	riskFreeRate := configuration.RiskFreeRate / weeksPerYear
+	downsideDeviation := commons.StdDev(downside)
+	weeklySortinoRatio := (meanReturn - riskFreeRate) / downsideDeviation
+	sortinoRatio := math.Sqrt(weeksPerYear) * weeklySortinoRatio
+	if math.IsInf(sortinoRatio, 1) || math.IsInf(sortinoRatio, -1) {
+		return math.NaN()
+	}
+	return sortinoRatio
+}
+
+// getCalmarRatio is the annualized return divided by the maximum drawdown,
+// where numWeeks is the number of weekly returns the totalReturn covers.
+func getCalmarRatio(totalReturn float64, maxDrawdown float64, numWeeks int) float64 {
+	if maxDrawdown == 0.0 || numWeeks == 0 {
+		return math.NaN()
+	}
+	annualizedReturn := totalReturn * weeksPerYear / float64(numWeeks)
+	calmarRatio := annualizedReturn / maxDrawdown
+	return calmarRatio
+}
+
+func getProfitFactor(wins []float64, losses []float64) float64 {
+	grossProfit := 0.0
+	for _, w := range wins {
+		grossProfit += w
+	}
+	grossLoss := 0.0
+	for _, l := range losses {
+		grossLoss += l
+	}
+	if grossLoss == 0.0 {
+		return math.NaN()
+	}
+	return grossProfit / math.Abs(grossLoss)
+}
+
+// getMaxDrawdown walks the cumulative equity curve implied by a return series
+// and tracks the largest peak-to-trough decline.
+func getMaxDrawdown(returns []float64) float64 {
+	equity := 1.0
+	peak := 1.0
+	maxDrawdown := 0.0
+	for _, r := range returns {
+		equity *= 1.0 + r
+		peak = math.Max(peak, equity)
+		drawdown := peak - equity
+		maxDrawdown = math.Max(maxDrawdown, drawdown)
+	}
+	return maxDrawdown
+}
+
+func writeSummaryReport(assets []AssetSummary) {
+	if configuration.SummaryReportPath == "" {
+		return
+	}
+	report := SummaryReport{
+		GeneratedAt: time.Now(),
+		StartDate: configuration.StartDate.Time,
+		SplitDate: configuration.SplitDate.Time,
+		EndDate: configuration.EndDate.Time,
+		Assets: assets,
+	}
+	data, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		commons.Fatalf("Failed to serialize summary report: %v", err)
+	}
+	err = os.WriteFile(configuration.SummaryReportPath, data, 0644)
+	if err != nil {
+		commons.Fatalf("Failed to write summary report to \"%s\": %v", configuration.SummaryReportPath, err)
+	}
+}
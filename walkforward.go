@@ -0,0 +1,81 @@
+package main
+
+import (
+	"time"
+
+	"github.com/encratite/commons"
+)
+
+const walkForwardModeRolling = "rolling"
+
+// walkForwardResult is the stitched-together outcome of repeatedly refitting
+// a model on a training window and evaluating it on the following window.
+type walkForwardResult struct {
+	model Model
+	trainingFeatures [][]float64
+	trainingLabels []float64
+	oosFeatures [][]float64
+	oosLabels []float64
+	oosDates []time.Time
+	oosSignals []float64
+	oosR2Score float64
+	foldR2Scores []float64
+}
+
+// runWalkForward fits configuration.WalkForwardMode ("expanding" or
+// "rolling") windows of size WalkForwardWindow, each followed by a
+// WalkForwardStep-sized out-of-sample slice, and stitches every fold's OOS
+// predictions and labels into a single series.
+func runWalkForward(symbol string, samples []sample) walkForwardResult {
+	window := configuration.WalkForwardWindow
+	step := configuration.WalkForwardStep
+	rolling := configuration.WalkForwardMode == walkForwardModeRolling
+	result := walkForwardResult{}
+	if step <= 0 {
+		commons.Fatalf("WalkForwardStep must be positive for \"%s\" (got %d); the fold loop would never advance", symbol, step)
+	}
+	trainStart := 0
+	trainEnd := window
+	if trainEnd >= len(samples) {
+		commons.Fatalf("Walk-forward window (%d) leaves no samples to evaluate for \"%s\" (%d samples available); reduce WalkForwardWindow", window, symbol, len(samples))
+	}
+	for trainEnd < len(samples) {
+		testEnd := min(trainEnd + step, len(samples))
+		trainingFeatures, trainingLabels := splitSamples(samples[trainStart:trainEnd])
+		testFeatures, testLabels := splitSamples(samples[trainEnd:testEnd])
+		model, err := newModel(configuration.Model)
+		if err != nil {
+			commons.Fatalf("Failed to create walk-forward model: %v", err)
+		}
+		err = model.Fit(trainingFeatures, trainingLabels)
+		if err != nil {
+			commons.Fatalf("Failed to fit walk-forward model: %v", err)
+		}
+		foldSignals := getSignals(testFeatures, model)
+		foldR2Score := getR2ScoreFromSignals(testLabels, foldSignals)
+		result.foldR2Scores = append(result.foldR2Scores, foldR2Score)
+		result.oosFeatures = append(result.oosFeatures, testFeatures...)
+		result.oosLabels = append(result.oosLabels, testLabels...)
+		result.oosDates = append(result.oosDates, getSampleDates(samples[trainEnd:testEnd])...)
+		result.oosSignals = append(result.oosSignals, foldSignals...)
+		result.model = model
+		result.trainingFeatures = trainingFeatures
+		result.trainingLabels = trainingLabels
+		if rolling {
+			trainStart += step
+		}
+		trainEnd += step
+	}
+	result.oosR2Score = getR2ScoreFromSignals(result.oosLabels, result.oosSignals)
+	return result
+}
+
+func splitSamples(samples []sample) ([][]float64, []float64) {
+	features := make([][]float64, len(samples))
+	labels := make([]float64, len(samples))
+	for i, s := range samples {
+		features[i] = s.features
+		labels[i] = s.label
+	}
+	return features, labels
+}